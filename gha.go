@@ -0,0 +1,154 @@
+package main
+
+import (
+	"dagger/dagger-2-gha/internal/dagger"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A Github Actions workflow
+type Workflow struct {
+	Name        string            `yaml:"name"`
+	On          WorkflowTriggers  `yaml:"on"`
+	Concurrency *ConcurrencyGroup `yaml:"concurrency,omitempty"`
+	Jobs        map[string]Job    `yaml:"jobs"`
+}
+
+type ConcurrencyGroup struct {
+	Group            string `yaml:"group"`
+	CancelInProgress bool   `yaml:"cancel-in-progress,omitempty"`
+}
+
+// Render the workflow to a Github config directory, under .github/workflows
+func (w Workflow) Config(filename string) *dagger.Directory {
+	data, err := yaml.Marshal(w)
+	if err != nil {
+		panic(err)
+	}
+	return dag.Directory().WithNewFile(".github/workflows/"+filename, string(data))
+}
+
+type WorkflowTriggers struct {
+	Push             *PushEvent             `yaml:"push,omitempty"`
+	PullRequest      *PullRequestEvent      `yaml:"pull_request,omitempty"`
+	Schedule         []ScheduleEvent        `yaml:"schedule,omitempty"`
+	WorkflowDispatch *WorkflowDispatchEvent `yaml:"workflow_dispatch,omitempty"`
+}
+
+type PushEvent struct {
+	Branches []string `yaml:"branches,omitempty"`
+	Tags     []string `yaml:"tags,omitempty"`
+}
+
+type PullRequestEvent struct {
+	Branches []string `yaml:"branches,omitempty"`
+}
+
+type ScheduleEvent struct {
+	Cron string `yaml:"cron"`
+}
+
+type WorkflowDispatchEvent struct {
+	Inputs map[string]WorkflowDispatchInput `yaml:"inputs,omitempty"`
+}
+
+type WorkflowDispatchInput struct {
+	Description string   `yaml:"description,omitempty"`
+	Type        string   `yaml:"type,omitempty"`
+	Default     string   `yaml:"default,omitempty"`
+	Options     []string `yaml:"options,omitempty"`
+	Required    bool     `yaml:"required,omitempty"`
+}
+
+type Job struct {
+	RunsOn      RunsOn               `yaml:"runs-on"`
+	Needs       []string             `yaml:"needs,omitempty"`
+	Strategy    *Strategy            `yaml:"strategy,omitempty"`
+	Permissions map[string]string    `yaml:"permissions,omitempty"`
+	Container   *Container           `yaml:"container,omitempty"`
+	Services    map[string]Container `yaml:"services,omitempty"`
+	Steps       []JobStep            `yaml:"steps"`
+	Outputs     map[string]string    `yaml:"outputs,omitempty"`
+}
+
+// A container a job (or a service sidecar) runs in
+type Container struct {
+	Image   string            `yaml:"image"`
+	Env     map[string]string `yaml:"env,omitempty"`
+	Ports   []string          `yaml:"ports,omitempty"`
+	Volumes []string          `yaml:"volumes,omitempty"`
+	Options string            `yaml:"options,omitempty"`
+}
+
+// The runner(s) a job executes on. Renders as a single scalar label when it
+// has exactly one value, or as a list otherwise (e.g. a self-hosted runner
+// label set).
+type RunsOn []string
+
+func (r RunsOn) MarshalYAML() (interface{}, error) {
+	if len(r) == 1 {
+		return r[0], nil
+	}
+	return []string(r), nil
+}
+
+type Strategy struct {
+	FailFast    *bool       `yaml:"fail-fast,omitempty"`
+	MaxParallel int         `yaml:"max-parallel,omitempty"`
+	Matrix      interface{} `yaml:"matrix"`
+}
+
+type JobStep struct {
+	ID   string            `yaml:"id,omitempty"`
+	Name string            `yaml:"name,omitempty"`
+	If   string            `yaml:"if,omitempty"`
+	Uses string            `yaml:"uses,omitempty"`
+	Run  string            `yaml:"run,omitempty"`
+	With map[string]string `yaml:"with,omitempty"`
+	Env  map[string]string `yaml:"env,omitempty"`
+}
+
+// A Github composite action
+type Action struct {
+	Name        string                  `yaml:"name"`
+	Description string                  `yaml:"description,omitempty"`
+	Inputs      map[string]ActionInput  `yaml:"inputs,omitempty"`
+	Outputs     map[string]ActionOutput `yaml:"outputs,omitempty"`
+	Runs        Runs                    `yaml:"runs"`
+}
+
+// Render the action to a Github config directory, under .github/actions/<name>
+func (a Action) Config(dirname string) *dagger.Directory {
+	data, err := yaml.Marshal(a)
+	if err != nil {
+		panic(err)
+	}
+	return dag.Directory().WithNewFile(".github/actions/"+dirname+"/action.yml", string(data))
+}
+
+type ActionInput struct {
+	Description string `yaml:"description,omitempty"`
+	Default     string `yaml:"default,omitempty"`
+	Required    bool   `yaml:"required,omitempty"`
+}
+
+type ActionOutput struct {
+	Description string `yaml:"description,omitempty"`
+	Value       string `yaml:"value"`
+}
+
+type Runs struct {
+	Using string                `yaml:"using"`
+	Steps []CompositeActionStep `yaml:"steps"`
+}
+
+type CompositeActionStep struct {
+	ID    string            `yaml:"id,omitempty"`
+	Name  string            `yaml:"name,omitempty"`
+	If    string            `yaml:"if,omitempty"`
+	Uses  string            `yaml:"uses,omitempty"`
+	Run   string            `yaml:"run,omitempty"`
+	Shell string            `yaml:"shell,omitempty"`
+	With  map[string]string `yaml:"with,omitempty"`
+	Env   map[string]string `yaml:"env,omitempty"`
+}
@@ -10,7 +10,6 @@ package main
 import (
 	"dagger/dagger-2-gha/internal/dagger"
 	"fmt"
-	"strings"
 )
 
 func New(
@@ -40,11 +39,34 @@ type Dagger2Gha struct {
 	// +private
 	PullRequestTriggers []PullRequestTrigger
 	// +private
+	ScheduleTriggers []ScheduleTrigger
+	// +private
+	DispatchTriggers []DispatchTrigger
+	// +private
 	PublicToken string
 	// +private
 	DaggerVersion string
 	// +private
 	NoTraces bool
+	// +private
+	Concurrency *ConcurrencyConfig
+}
+
+// Set a default concurrency group for the workflow of every trigger added
+// after this call (triggers added before it keep their own), so superseded
+// runs (e.g. repeated pushes to the same pull request) are automatically
+// cancelled. Can be overridden per-pipeline via Pipeline.WithConcurrency.
+func (m *Dagger2Gha) WithConcurrency(
+	// The concurrency group name. Runs sharing a group are queued, and
+	// optionally cancelled, against one another.
+	// Example '${{ github.workflow }}-${{ github.ref }}'
+	group string,
+	// Cancel any run still in progress when a new one starts in the same group
+	// +optional
+	cancelInProgress bool,
+) *Dagger2Gha {
+	m.Concurrency = &ConcurrencyConfig{Group: group, CancelInProgress: cancelInProgress}
+	return m
 }
 
 // Add a trigger to execute a Dagger pipeline on a git push
@@ -59,13 +81,81 @@ func (m *Dagger2Gha) OnPush(
 	branches []string,
 	// +optional
 	tags []string,
+	// The Github Actions runner(s) to execute the pipeline on. Pass more than
+	// one label to target a self-hosted runner label set.
+	// +optional
+	runsOn []string,
+	// Run the pipeline across every combination of the given axes, e.g.
+	// {"os": ["ubuntu-latest", "macos-latest"]}
+	// +optional
+	matrix map[string][]string,
+	// Cancel the matrix's remaining legs as soon as one fails. Defaults to
+	// Github's own default (true) when left unset; pass false explicitly to
+	// let every leg run to completion.
+	// +optional
+	matrixFailFast *bool,
+	// Cap how many matrix legs run concurrently
+	// +optional
+	matrixMaxParallel int,
+	// Extra combinations to include in the matrix
+	// +optional
+	matrixInclude []map[string]string,
+	// Combinations to exclude from the matrix
+	// +optional
+	matrixExclude []map[string]string,
+	// Split the pipeline into a DAG of staged jobs instead of a single job,
+	// wired together with 'needs:' according to each stage's DependsOn
+	// +optional
+	stages []Stage,
+	// Github Actions secrets to expose to the pipeline's Dagger CLI invocation
+	// +optional
+	secrets []SecretRef,
+	// Environment variables to set on the pipeline's Dagger CLI invocation
+	// +optional
+	env map[string]string,
+	// Github Actions permissions to grant the pipeline's job, e.g. {"contents": "write"}
+	// +optional
+	permissions map[string]string,
+	// The ARN of an AWS IAM role to assume via Github OIDC, trusting the
+	// Github OIDC provider. Requires awsOIDCRegion.
+	// +optional
+	awsOIDCRoleARN string,
+	// The AWS region to operate in, when awsOIDCRoleARN is set
+	// +optional
+	awsOIDCRegion string,
+	// Override the default concurrency group for this pipeline's workflow,
+	// cancelling superseded runs. See Dagger2Gha.WithConcurrency.
+	// +optional
+	concurrencyGroup string,
+	// Cancel any run still in progress when a new one starts in the same
+	// concurrencyGroup
+	// +optional
+	concurrencyCancelInProgress bool,
+	// Run this pipeline's job inside a container instead of directly on the
+	// runner, pinning the exact image the Dagger CLI runs alongside
+	// +optional
+	containerImage string,
+	// +optional
+	containerOptions ContainerOptions,
 ) *Dagger2Gha {
+	pipeline := m.pipeline(command, module)
+	pipeline.ActionName = fmt.Sprintf("push-%d", len(m.PushTriggers)+1)
+	if len(runsOn) > 0 {
+		pipeline = pipeline.WithRunsOn(runsOn)
+	}
+	if len(matrix) > 0 {
+		pipeline = pipeline.WithMatrix(matrix, matrixFailFast, matrixMaxParallel, matrixInclude, matrixExclude)
+	}
+	for _, stage := range stages {
+		pipeline = pipeline.WithStage(stage.Name, stage.Command, stage.DependsOn, stage.Artifacts)
+	}
+	pipeline = m.withPipelineOptions(pipeline, secrets, env, permissions, awsOIDCRoleARN, awsOIDCRegion, concurrencyGroup, concurrencyCancelInProgress, containerImage, containerOptions)
 	m.PushTriggers = append(m.PushTriggers, PushTrigger{
 		Event: PushEvent{
 			Branches: branches,
 			Tags:     tags,
 		},
-		Pipeline: m.pipeline(command, module),
+		Pipeline: pipeline,
 	})
 	return m
 }
@@ -80,12 +170,228 @@ func (m *Dagger2Gha) OnPullRequest(
 	module string,
 	// +optional
 	branches []string,
+	// Post the pipeline's output as a sticky comment on the pull request, upserted
+	// across runs using this header as a marker
+	// +optional
+	prComment string,
+	// The Github Actions runner(s) to execute the pipeline on. Pass more than
+	// one label to target a self-hosted runner label set.
+	// +optional
+	runsOn []string,
+	// Run the pipeline across every combination of the given axes, e.g.
+	// {"os": ["ubuntu-latest", "macos-latest"]}
+	// +optional
+	matrix map[string][]string,
+	// Cancel the matrix's remaining legs as soon as one fails. Defaults to
+	// Github's own default (true) when left unset; pass false explicitly to
+	// let every leg run to completion.
+	// +optional
+	matrixFailFast *bool,
+	// Cap how many matrix legs run concurrently
+	// +optional
+	matrixMaxParallel int,
+	// Extra combinations to include in the matrix
+	// +optional
+	matrixInclude []map[string]string,
+	// Combinations to exclude from the matrix
+	// +optional
+	matrixExclude []map[string]string,
+	// Split the pipeline into a DAG of staged jobs instead of a single job,
+	// wired together with 'needs:' according to each stage's DependsOn
+	// +optional
+	stages []Stage,
+	// Github Actions secrets to expose to the pipeline's Dagger CLI invocation
+	// +optional
+	secrets []SecretRef,
+	// Environment variables to set on the pipeline's Dagger CLI invocation
+	// +optional
+	env map[string]string,
+	// Github Actions permissions to grant the pipeline's job, e.g. {"contents": "write"}
+	// +optional
+	permissions map[string]string,
+	// The ARN of an AWS IAM role to assume via Github OIDC, trusting the
+	// Github OIDC provider. Requires awsOIDCRegion.
+	// +optional
+	awsOIDCRoleARN string,
+	// The AWS region to operate in, when awsOIDCRoleARN is set
+	// +optional
+	awsOIDCRegion string,
+	// Override the default concurrency group for this pipeline's workflow,
+	// cancelling superseded runs. See Dagger2Gha.WithConcurrency.
+	// +optional
+	concurrencyGroup string,
+	// Cancel any run still in progress when a new one starts in the same
+	// concurrencyGroup
+	// +optional
+	concurrencyCancelInProgress bool,
+	// Run this pipeline's job inside a container instead of directly on the
+	// runner, pinning the exact image the Dagger CLI runs alongside
+	// +optional
+	containerImage string,
+	// +optional
+	containerOptions ContainerOptions,
 ) *Dagger2Gha {
+	pipeline := m.pipeline(command, module)
+	pipeline.ActionName = fmt.Sprintf("pr-%d", len(m.PullRequestTriggers)+1)
+	if prComment != "" {
+		pipeline = pipeline.WithPRComment(prComment)
+	}
+	if len(runsOn) > 0 {
+		pipeline = pipeline.WithRunsOn(runsOn)
+	}
+	if len(matrix) > 0 {
+		pipeline = pipeline.WithMatrix(matrix, matrixFailFast, matrixMaxParallel, matrixInclude, matrixExclude)
+	}
+	for _, stage := range stages {
+		pipeline = pipeline.WithStage(stage.Name, stage.Command, stage.DependsOn, stage.Artifacts)
+	}
+	pipeline = m.withPipelineOptions(pipeline, secrets, env, permissions, awsOIDCRoleARN, awsOIDCRegion, concurrencyGroup, concurrencyCancelInProgress, containerImage, containerOptions)
 	m.PullRequestTriggers = append(m.PullRequestTriggers, PullRequestTrigger{
 		Event: PullRequestEvent{
 			Branches: branches,
 		},
-		Pipeline: m.pipeline(command, module),
+		Pipeline: pipeline,
+	})
+	return m
+}
+
+// Add a trigger to execute a Dagger pipeline on a schedule
+func (m *Dagger2Gha) OnSchedule(
+	// The cron expression defining the schedule
+	// Example '0 0 * * *'
+	cron string,
+	// The Dagger command to execute
+	// Example 'build --source=.'
+	command string,
+	// +optional
+	// +default="."
+	module string,
+	// The Github Actions runner(s) to execute the pipeline on. Pass more than
+	// one label to target a self-hosted runner label set.
+	// +optional
+	runsOn []string,
+	// Split the pipeline into a DAG of staged jobs instead of a single job,
+	// wired together with 'needs:' according to each stage's DependsOn
+	// +optional
+	stages []Stage,
+	// Github Actions secrets to expose to the pipeline's Dagger CLI invocation
+	// +optional
+	secrets []SecretRef,
+	// Environment variables to set on the pipeline's Dagger CLI invocation
+	// +optional
+	env map[string]string,
+	// Github Actions permissions to grant the pipeline's job, e.g. {"contents": "write"}
+	// +optional
+	permissions map[string]string,
+	// The ARN of an AWS IAM role to assume via Github OIDC, trusting the
+	// Github OIDC provider. Requires awsOIDCRegion.
+	// +optional
+	awsOIDCRoleARN string,
+	// The AWS region to operate in, when awsOIDCRoleARN is set
+	// +optional
+	awsOIDCRegion string,
+	// Override the default concurrency group for this pipeline's workflow,
+	// cancelling superseded runs. See Dagger2Gha.WithConcurrency.
+	// +optional
+	concurrencyGroup string,
+	// Cancel any run still in progress when a new one starts in the same
+	// concurrencyGroup
+	// +optional
+	concurrencyCancelInProgress bool,
+	// Run this pipeline's job inside a container instead of directly on the
+	// runner, pinning the exact image the Dagger CLI runs alongside
+	// +optional
+	containerImage string,
+	// +optional
+	containerOptions ContainerOptions,
+) *Dagger2Gha {
+	pipeline := m.pipeline(command, module)
+	pipeline.ActionName = fmt.Sprintf("schedule-%d", len(m.ScheduleTriggers)+1)
+	if len(runsOn) > 0 {
+		pipeline = pipeline.WithRunsOn(runsOn)
+	}
+	for _, stage := range stages {
+		pipeline = pipeline.WithStage(stage.Name, stage.Command, stage.DependsOn, stage.Artifacts)
+	}
+	pipeline = m.withPipelineOptions(pipeline, secrets, env, permissions, awsOIDCRoleARN, awsOIDCRegion, concurrencyGroup, concurrencyCancelInProgress, containerImage, containerOptions)
+	m.ScheduleTriggers = append(m.ScheduleTriggers, ScheduleTrigger{
+		Event:    ScheduleEvent{Cron: cron},
+		Pipeline: pipeline,
+	})
+	return m
+}
+
+// Add a trigger to manually execute a Dagger pipeline from the Github UI or API
+func (m *Dagger2Gha) OnDispatch(
+	// The Dagger command to execute
+	// Example 'deploy --env=${{ inputs.environment }}'
+	command string,
+	// +optional
+	// +default="."
+	module string,
+	// Inputs exposed to the workflow_dispatch trigger. Each one can be templated
+	// into the Dagger command as '${{ inputs.<name> }}'
+	// +optional
+	inputs []DispatchInput,
+	// The Github Actions runner(s) to execute the pipeline on. Pass more than
+	// one label to target a self-hosted runner label set.
+	// +optional
+	runsOn []string,
+	// Split the pipeline into a DAG of staged jobs instead of a single job,
+	// wired together with 'needs:' according to each stage's DependsOn
+	// +optional
+	stages []Stage,
+	// Github Actions secrets to expose to the pipeline's Dagger CLI invocation
+	// +optional
+	secrets []SecretRef,
+	// Environment variables to set on the pipeline's Dagger CLI invocation
+	// +optional
+	env map[string]string,
+	// Github Actions permissions to grant the pipeline's job, e.g. {"contents": "write"}
+	// +optional
+	permissions map[string]string,
+	// The ARN of an AWS IAM role to assume via Github OIDC, trusting the
+	// Github OIDC provider. Requires awsOIDCRegion.
+	// +optional
+	awsOIDCRoleARN string,
+	// The AWS region to operate in, when awsOIDCRoleARN is set
+	// +optional
+	awsOIDCRegion string,
+	// Override the default concurrency group for this pipeline's workflow,
+	// cancelling superseded runs. See Dagger2Gha.WithConcurrency.
+	// +optional
+	concurrencyGroup string,
+	// Cancel any run still in progress when a new one starts in the same
+	// concurrencyGroup
+	// +optional
+	concurrencyCancelInProgress bool,
+	// Run this pipeline's job inside a container instead of directly on the
+	// runner, pinning the exact image the Dagger CLI runs alongside
+	// +optional
+	containerImage string,
+	// +optional
+	containerOptions ContainerOptions,
+) *Dagger2Gha {
+	event := WorkflowDispatchEvent{}
+	pipeline := m.pipeline(command, module)
+	pipeline.ActionName = fmt.Sprintf("dispatch-%d", len(m.DispatchTriggers)+1)
+	if len(runsOn) > 0 {
+		pipeline = pipeline.WithRunsOn(runsOn)
+	}
+	if len(inputs) > 0 {
+		event.Inputs = make(map[string]WorkflowDispatchInput, len(inputs))
+		for _, input := range inputs {
+			event.Inputs[input.Name] = input.asWorkflowDispatchInput()
+		}
+		pipeline = pipeline.WithInputs(inputs)
+	}
+	for _, stage := range stages {
+		pipeline = pipeline.WithStage(stage.Name, stage.Command, stage.DependsOn, stage.Artifacts)
+	}
+	pipeline = m.withPipelineOptions(pipeline, secrets, env, permissions, awsOIDCRoleARN, awsOIDCRegion, concurrencyGroup, concurrencyCancelInProgress, containerImage, containerOptions)
+	m.DispatchTriggers = append(m.DispatchTriggers, DispatchTrigger{
+		Event:    event,
+		Pipeline: pipeline,
 	})
 	return m
 }
@@ -104,10 +410,50 @@ func (m *Dagger2Gha) pipeline(
 		NoTraces:      m.NoTraces,
 		Command:       command,
 		Module:        module,
+		RunsOn:        []string{"ubuntu-latest"},
+		Concurrency:   m.Concurrency,
 	}
 }
 
-// Generate a github config directory, usable as an overlay on the repository root
+// Apply the secrets, env vars, permissions and AWS OIDC config shared by every
+// trigger method, so On* methods don't each repeat this wiring.
+func (m *Dagger2Gha) withPipelineOptions(
+	pipeline Pipeline,
+	secrets []SecretRef,
+	env map[string]string,
+	permissions map[string]string,
+	awsOIDCRoleARN string,
+	awsOIDCRegion string,
+	concurrencyGroup string,
+	concurrencyCancelInProgress bool,
+	containerImage string,
+	containerOptions ContainerOptions,
+) Pipeline {
+	for _, secret := range secrets {
+		pipeline = pipeline.WithSecret(secret.Name, secret.EnvName)
+	}
+	for name, value := range env {
+		pipeline = pipeline.WithEnv(name, value)
+	}
+	for scope, access := range permissions {
+		pipeline = pipeline.WithPermission(scope, access)
+	}
+	if awsOIDCRoleARN != "" {
+		pipeline = pipeline.WithAWSOIDC(awsOIDCRoleARN, awsOIDCRegion)
+	}
+	if concurrencyGroup != "" {
+		pipeline = pipeline.WithConcurrency(concurrencyGroup, concurrencyCancelInProgress)
+	}
+	if containerImage != "" {
+		pipeline = pipeline.WithContainerRunner(containerImage, containerOptions)
+	}
+	return pipeline
+}
+
+// Generate a github config directory of workflows, usable as an overlay on
+// the repository root. Each generated workflow invokes its pipeline via
+// './.github/actions/<name>', so this directory must be merged with the one
+// from Actions() for the workflows to find their composite actions and run.
 func (m *Dagger2Gha) Config() *dagger.Directory {
 	dir := dag.Directory()
 	for i, t := range m.PushTriggers {
@@ -118,6 +464,40 @@ func (m *Dagger2Gha) Config() *dagger.Directory {
 		filename := fmt.Sprintf("pr-%d.yml", i+1)
 		dir = dir.WithDirectory(".", t.Config(filename))
 	}
+	for i, t := range m.ScheduleTriggers {
+		filename := fmt.Sprintf("schedule-%d.yml", i+1)
+		dir = dir.WithDirectory(".", t.Config(filename))
+	}
+	for i, t := range m.DispatchTriggers {
+		filename := fmt.Sprintf("dispatch-%d.yml", i+1)
+		dir = dir.WithDirectory(".", t.Config(filename))
+	}
+	return dir
+}
+
+// Generate a directory of reusable composite actions, one per pipeline (one
+// per stage for a staged pipeline), under .github/actions/<name>. Each generated workflow invokes its pipeline through
+// the matching action, so the same pipeline can also be called from other
+// workflows, or vendored into external repositories.
+func (m *Dagger2Gha) Actions() *dagger.Directory {
+	dir := dag.Directory()
+	addActions := func(p Pipeline) {
+		for _, invocation := range p.actionPipelines() {
+			dir = dir.WithDirectory(".", invocation.AsAction(invocation.ActionName))
+		}
+	}
+	for _, t := range m.PushTriggers {
+		addActions(t.Pipeline)
+	}
+	for _, t := range m.PullRequestTriggers {
+		addActions(t.Pipeline)
+	}
+	for _, t := range m.ScheduleTriggers {
+		addActions(t.Pipeline)
+	}
+	for _, t := range m.DispatchTriggers {
+		addActions(t.Pipeline)
+	}
 	return dir
 }
 
@@ -151,6 +531,66 @@ func (t PullRequestTrigger) Config(filename string) *dagger.Directory {
 	return t.asWorkflow().Config(filename)
 }
 
+type ScheduleTrigger struct {
+	Event    ScheduleEvent
+	Pipeline Pipeline
+}
+
+func (t ScheduleTrigger) asWorkflow() Workflow {
+	var workflow = t.Pipeline.asWorkflow()
+	workflow.On = WorkflowTriggers{Schedule: []ScheduleEvent{t.Event}}
+	return workflow
+}
+
+func (t ScheduleTrigger) Config(filename string) *dagger.Directory {
+	return t.asWorkflow().Config(filename)
+}
+
+type DispatchTrigger struct {
+	Event    WorkflowDispatchEvent
+	Pipeline Pipeline
+}
+
+func (t DispatchTrigger) asWorkflow() Workflow {
+	var workflow = t.Pipeline.asWorkflow()
+	workflow.On = WorkflowTriggers{WorkflowDispatch: &(t.Event)}
+	return workflow
+}
+
+func (t DispatchTrigger) Config(filename string) *dagger.Directory {
+	return t.asWorkflow().Config(filename)
+}
+
+// An input parameter for a workflow_dispatch trigger, rendered into the
+// generated workflow's 'on.workflow_dispatch.inputs' block
+type DispatchInput struct {
+	// The input's name, referenced in the Dagger command as '${{ inputs.<name> }}'
+	Name string
+	// +optional
+	Description string
+	// The input type: one of 'string', 'boolean', 'choice' or 'number'
+	// +optional
+	// +default="string"
+	Type string
+	// +optional
+	Default string
+	// The list of allowed values, when Type is 'choice'
+	// +optional
+	Options []string
+	// +optional
+	Required bool
+}
+
+func (i DispatchInput) asWorkflowDispatchInput() WorkflowDispatchInput {
+	return WorkflowDispatchInput{
+		Description: i.Description,
+		Type:        i.Type,
+		Default:     i.Default,
+		Options:     i.Options,
+		Required:    i.Required,
+	}
+}
+
 type Pipeline struct {
 	// +private
 	DaggerVersion string
@@ -162,84 +602,632 @@ type Pipeline struct {
 	Command string
 	// +private
 	NoTraces bool
+	// +private
+	PRCommentHeader string
+	// +private
+	RunsOn []string
+	// +private
+	Matrix *Matrix
+	// +private
+	Secrets []SecretRef
+	// +private
+	Env map[string]string
+	// +private
+	Permissions map[string]string
+	// +private
+	AWSOIDC *AWSOIDCConfig
+	// +private
+	Inputs []DispatchInput
+	// +private
+	Stages []Stage
+	// +private
+	Concurrency *ConcurrencyConfig
+	// +private
+	ContainerImage string
+	// +private
+	ContainerOptions ContainerOptions
+	// +private
+	ActionName string
 }
 
-func (p *Pipeline) Name() string {
-	return strings.SplitN(p.Command, " ", 2)[0]
+// A concurrency group, cancelling superseded workflow runs that share it
+type ConcurrencyConfig struct {
+	Group            string
+	CancelInProgress bool
+}
+
+func (c ConcurrencyConfig) asConcurrencyGroup() ConcurrencyGroup {
+	return ConcurrencyGroup{
+		Group:            c.Group,
+		CancelInProgress: c.CancelInProgress,
+	}
+}
+
+// Set a concurrency group for this pipeline's workflow, cancelling superseded
+// runs. Overrides any workflow-default set via Dagger2Gha.WithConcurrency.
+func (p Pipeline) WithConcurrency(
+	// The concurrency group name. Runs sharing a group are queued, and
+	// optionally cancelled, against one another.
+	// Example '${{ github.workflow }}-${{ github.ref }}'
+	group string,
+	// Cancel any run still in progress when a new one starts in the same group
+	// +optional
+	cancelInProgress bool,
+) Pipeline {
+	p.Concurrency = &ConcurrencyConfig{Group: group, CancelInProgress: cancelInProgress}
+	return p
+}
+
+// Options for running a pipeline's job inside a container, instead of
+// directly on the runner
+type ContainerOptions struct {
+	// +optional
+	Volumes []string
+	// +optional
+	Env map[string]string
+	// +optional
+	Ports []string
+	// Extra options passed to the Docker daemon when creating the container,
+	// e.g. "--cpus 2"
+	// +optional
+	DockerOptions string
+	// Sidecar service containers available to the job, keyed by hostname
+	// +optional
+	Services []ServiceContainer
+}
+
+// A sidecar service container, available to a job's steps at Hostname
+type ServiceContainer struct {
+	Hostname string
+	Image    string
+	// +optional
+	Env map[string]string
+	// +optional
+	Ports []string
+}
+
+// Run this pipeline's job inside a container, instead of directly on the bare
+// runner. Lets you pin the exact base image, volumes, and services the
+// Dagger CLI runs alongside.
+func (p Pipeline) WithContainerRunner(
+	// The container image the job's steps execute in
+	image string,
+	// +optional
+	options ContainerOptions,
+) Pipeline {
+	p.ContainerImage = image
+	p.ContainerOptions = options
+	return p
+}
+
+// A named stage in a pipeline's job DAG. Each stage runs as its own job in
+// the generated workflow, wired together with 'needs:' according to
+// DependsOn. A stage's command can consume upstream values via
+// '${{ needs.<stage>.outputs.output }}', capturing the upstream Dagger call's
+// stdout.
+type Stage struct {
+	// The stage's name. Becomes the job id, and is referenced by dependent
+	// stages' DependsOn.
+	Name string
+	// The Dagger command to execute for this stage
+	Command string
+	// +optional
+	DependsOn []string
+	// Artifacts this stage uploads after its Dagger call, downloaded
+	// automatically by stages that depend on it
+	// +optional
+	Artifacts []ArtifactSpec
+}
+
+// An artifact uploaded by a stage via actions/upload-artifact, and
+// downloaded via actions/download-artifact by dependent stages
+type ArtifactSpec struct {
+	Name string
+	Path string
+}
+
+// Add a stage to this pipeline's job DAG. Each call adds one job; wire
+// multiple stages together with dependsOn to express e.g. lint and test in
+// parallel, then build, then publish.
+func (p Pipeline) WithStage(
+	// The stage's name. Becomes the job id, and is referenced by dependent
+	// stages' dependsOn.
+	name string,
+	// The Dagger command to execute for this stage
+	command string,
+	// The names of stages that must complete before this one starts
+	// +optional
+	dependsOn []string,
+	// Artifacts this stage uploads after its Dagger call, downloaded
+	// automatically by stages that depend on it
+	// +optional
+	artifacts []ArtifactSpec,
+) Pipeline {
+	p.Stages = append(p.Stages, Stage{
+		Name:      name,
+		Command:   command,
+		DependsOn: dependsOn,
+		Artifacts: artifacts,
+	})
+	return p
+}
+
+// The distinct Dagger invocations in this pipeline, each rendered as its own
+// composite action. A pipeline with no stages is a single invocation; a
+// staged pipeline has one invocation per stage.
+func (p Pipeline) actionPipelines() []Pipeline {
+	if len(p.Stages) == 0 {
+		return []Pipeline{p}
+	}
+	pipelines := make([]Pipeline, 0, len(p.Stages))
+	for _, stage := range p.Stages {
+		pipelines = append(pipelines, p.stagePipeline(stage))
+	}
+	return pipelines
+}
+
+func (p Pipeline) stagePipeline(stage Stage) Pipeline {
+	p.Command = stage.Command
+	p.Stages = nil
+	p.ActionName = fmt.Sprintf("%s-%s", p.ActionName, stage.Name)
+	// Each stage runs as its own job; give it its own sticky-comment header so
+	// concurrent stages don't race to upsert the same PR comment (the same
+	// class of bug fixed for matrix legs in prCommentHeader).
+	if p.PRCommentHeader != "" {
+		p.PRCommentHeader = fmt.Sprintf("%s (%s)", p.PRCommentHeader, stage.Name)
+	}
+	return p
+}
+
+// Declare the named inputs this pipeline expects, rendered as composite
+// action inputs when the pipeline is exposed via AsAction, and referenced in
+// the Dagger command as '${{ inputs.<name> }}'
+func (p Pipeline) WithInputs(inputs []DispatchInput) Pipeline {
+	p.Inputs = inputs
+	return p
+}
+
+// A reference to a Github Actions secret, exposed to the pipeline's Dagger CLI
+// invocation as an environment variable
+type SecretRef struct {
+	// The name of the Github Actions secret, e.g. "MY_TOKEN" (exposed as '${{ secrets.MY_TOKEN }}')
+	Name string
+	// The environment variable the secret is exposed as. Defaults to Name.
+	// +optional
+	EnvName string
+}
+
+func (s SecretRef) envName() string {
+	if s.EnvName != "" {
+		return s.EnvName
+	}
+	return s.Name
+}
+
+// AWS credentials obtained via Github OIDC, configured by WithAWSOIDC
+type AWSOIDCConfig struct {
+	RoleARN string
+	Region  string
+}
+
+// Expose a Github Actions secret to the pipeline's Dagger CLI invocation
+func (p Pipeline) WithSecret(
+	// The name of the Github Actions secret, e.g. "MY_TOKEN" (exposed as '${{ secrets.MY_TOKEN }}')
+	name string,
+	// The environment variable the secret is exposed as. Defaults to name.
+	// +optional
+	envName string,
+) Pipeline {
+	p.Secrets = append(p.Secrets, SecretRef{Name: name, EnvName: envName})
+	return p
+}
+
+// Set an environment variable on the pipeline's Dagger CLI invocation
+func (p Pipeline) WithEnv(name string, value string) Pipeline {
+	env := make(map[string]string, len(p.Env)+1)
+	for k, v := range p.Env {
+		env[k] = v
+	}
+	env[name] = value
+	p.Env = env
+	return p
+}
+
+// Grant a Github Actions permission to the pipeline's job, e.g.
+// WithPermission("id-token", "write")
+func (p Pipeline) WithPermission(scope string, access string) Pipeline {
+	permissions := make(map[string]string, len(p.Permissions)+1)
+	for k, v := range p.Permissions {
+		permissions[k] = v
+	}
+	permissions[scope] = access
+	p.Permissions = permissions
+	return p
+}
+
+// Configure AWS credentials via Github OIDC (no long-lived AWS secrets
+// required), by adding an aws-actions/configure-aws-credentials step before
+// the Dagger call. Requests the 'id-token: write' permission needed to mint
+// the OIDC token.
+func (p Pipeline) WithAWSOIDC(
+	// The ARN of the AWS IAM role to assume, trusting the Github OIDC provider
+	roleARN string,
+	// The AWS region to operate in
+	region string,
+) Pipeline {
+	p.AWSOIDC = &AWSOIDCConfig{RoleARN: roleARN, Region: region}
+	return p.WithPermission("id-token", "write")
+}
+
+// A matrix strategy, fanning a single pipeline job out across every
+// combination of the given axes. RunsOn and the Dagger command can reference
+// the current combination via e.g. '${{ matrix.os }}'.
+type Matrix struct {
+	// +private
+	Axes map[string][]string
+	// +private
+	FailFast *bool
+	// +private
+	MaxParallel int
+	// +private
+	Include []map[string]string
+	// +private
+	Exclude []map[string]string
+}
+
+func (mx Matrix) asStrategy() Strategy {
+	matrix := map[string]interface{}{}
+	for axis, values := range mx.Axes {
+		matrix[axis] = values
+	}
+	if len(mx.Include) > 0 {
+		matrix["include"] = mx.Include
+	}
+	if len(mx.Exclude) > 0 {
+		matrix["exclude"] = mx.Exclude
+	}
+	return Strategy{
+		FailFast:    mx.FailFast,
+		MaxParallel: mx.MaxParallel,
+		Matrix:      matrix,
+	}
+}
+
+// Run this pipeline's job across a matrix of every combination of the given
+// axes, e.g. {"os": ["ubuntu-latest", "macos-latest"], "go": ["1.21", "1.22"]}
+func (p Pipeline) WithMatrix(
+	axes map[string][]string,
+	// Cancel the matrix's remaining legs as soon as one fails. Left unset,
+	// Github's own default (true) applies; pass false explicitly to let
+	// every leg run to completion.
+	// +optional
+	failFast *bool,
+	// +optional
+	maxParallel int,
+	// Extra combinations to include in the matrix
+	// +optional
+	include []map[string]string,
+	// Combinations to exclude from the matrix
+	// +optional
+	exclude []map[string]string,
+) Pipeline {
+	p.Matrix = &Matrix{
+		Axes:        axes,
+		FailFast:    failFast,
+		MaxParallel: maxParallel,
+		Include:     include,
+		Exclude:     exclude,
+	}
+	return p
+}
+
+// Set the Github Actions runner(s) this pipeline's job executes on. Pass
+// more than one label to target a self-hosted runner label set.
+func (p Pipeline) WithRunsOn(runsOn []string) Pipeline {
+	p.RunsOn = runsOn
+	return p
+}
+
+// Post the pipeline's output as a sticky comment on the pull request it runs against,
+// upserted across runs using header as a marker. Only meaningful for pipelines
+// triggered by OnPullRequest.
+func (p Pipeline) WithPRComment(
+	// Marker used to find and update ("upsert") a previous comment from this pipeline,
+	// instead of posting a new one on every run
+	// +optional
+	// +default="dagger2gha"
+	header string,
+) Pipeline {
+	p.PRCommentHeader = header
+	return p
 }
 
 // Generate a GHA workflow from a Dagger pipeline definition.
 // The workflow will have no triggers, they should be filled separately.
 func (p *Pipeline) asWorkflow() Workflow {
-	return Workflow{
+	workflow := Workflow{
 		Name: p.Command,
 		On:   WorkflowTriggers{}, // Triggers intentionally left blank
-		Jobs: map[string]Job{
-			"dagger": Job{
-				RunsOn: "ubuntu-latest",
-				Steps: []JobStep{
-					p.checkoutStep(),
-					p.callDaggerStep(),
-				},
-			},
+		Jobs: p.asJobs(),
+	}
+	if p.Concurrency != nil {
+		group := p.Concurrency.asConcurrencyGroup()
+		workflow.Concurrency = &group
+	}
+	return workflow
+}
+
+// The workflow's jobs: a single "dagger" job, or one job per stage wired
+// together with 'needs:' when WithStage has been used.
+func (p *Pipeline) asJobs() map[string]Job {
+	if len(p.Stages) == 0 {
+		return map[string]Job{"dagger": p.asJob()}
+	}
+	artifactsByStage := make(map[string][]ArtifactSpec, len(p.Stages))
+	for _, stage := range p.Stages {
+		artifactsByStage[stage.Name] = stage.Artifacts
+	}
+	jobs := make(map[string]Job, len(p.Stages))
+	for _, stage := range p.Stages {
+		stagePipeline := p.stagePipeline(stage)
+		job := stagePipeline.asJob()
+
+		var downloads []JobStep
+		for _, dep := range stage.DependsOn {
+			for _, artifact := range artifactsByStage[dep] {
+				downloads = append(downloads, artifact.downloadStep())
+			}
+		}
+		var uploads []JobStep
+		for _, artifact := range stage.Artifacts {
+			uploads = append(uploads, artifact.uploadStep())
+		}
+		// job.Steps[0] is the checkout and job.Steps[1] the "Call Dagger" step;
+		// splice downloads between them and uploads right after the call,
+		// ahead of any PR-comment steps.
+		steps := make([]JobStep, 0, len(downloads)+len(uploads)+len(job.Steps))
+		steps = append(steps, job.Steps[0])
+		steps = append(steps, downloads...)
+		steps = append(steps, job.Steps[1])
+		steps = append(steps, uploads...)
+		steps = append(steps, job.Steps[2:]...)
+		job.Steps = steps
+
+		job.Needs = stage.DependsOn
+		job.Outputs = map[string]string{"output": "${{ steps.dagger.outputs.output }}"}
+		jobs[stage.Name] = job
+	}
+	return jobs
+}
+
+func (p *Pipeline) asJob() Job {
+	// The repository must be checked out for './.github/actions/<name>' to resolve
+	steps := []JobStep{{Name: "Checkout", Uses: "actions/checkout@v4"}, p.callActionStep()}
+	steps = append(steps, p.prCommentSteps()...)
+	job := Job{
+		RunsOn: RunsOn(p.RunsOn),
+		Steps:  steps,
+	}
+	if p.Matrix != nil {
+		strategy := p.Matrix.asStrategy()
+		job.Strategy = &strategy
+	}
+	permissions := make(map[string]string, len(p.Permissions)+1)
+	for k, v := range p.Permissions {
+		permissions[k] = v
+	}
+	if p.PRCommentHeader != "" {
+		if _, ok := permissions["pull-requests"]; !ok {
+			permissions["pull-requests"] = "write"
+		}
+	}
+	if len(permissions) > 0 {
+		job.Permissions = permissions
+	}
+	if p.ContainerImage != "" {
+		job.Container = &Container{
+			Image:   p.ContainerImage,
+			Env:     p.ContainerOptions.Env,
+			Ports:   p.ContainerOptions.Ports,
+			Volumes: p.ContainerOptions.Volumes,
+			Options: p.ContainerOptions.DockerOptions,
+		}
+		if len(p.ContainerOptions.Services) > 0 {
+			job.Services = make(map[string]Container, len(p.ContainerOptions.Services))
+			for _, service := range p.ContainerOptions.Services {
+				job.Services[service.Hostname] = Container{
+					Image: service.Image,
+					Env:   service.Env,
+					Ports: service.Ports,
+				}
+			}
+		}
+	}
+	return job
+}
+
+func (a ArtifactSpec) uploadStep() JobStep {
+	return JobStep{
+		Name: fmt.Sprintf("Upload artifact %s", a.Name),
+		Uses: "actions/upload-artifact@v4",
+		With: map[string]string{
+			"name": a.Name,
+			"path": a.Path,
 		},
 	}
 }
 
-func (p *Pipeline) checkoutStep() JobStep {
+func (a ArtifactSpec) downloadStep() JobStep {
 	return JobStep{
-		Name: "Checkout",
-		Uses: "actions/checkout@v4",
+		Name: fmt.Sprintf("Download artifact %s", a.Name),
+		Uses: "actions/download-artifact@v4",
+		With: map[string]string{
+			"name": a.Name,
+			"path": a.Path,
+		},
 	}
 }
 
-func (p *Pipeline) callDaggerStep() JobStep {
+// The workflow step invoking this pipeline's composite action
+func (p *Pipeline) callActionStep() JobStep {
 	step := JobStep{
+		ID:   "dagger",
 		Name: "Call Dagger",
-		Uses: "dagger/dagger-for-github@v6",
-		With: map[string]string{
-			"version": "latest",
-			"module":  p.Module,
-			"args":    p.Command,
-		},
+		Uses: fmt.Sprintf("./.github/actions/%s", p.ActionName),
+	}
+	if len(p.Inputs) > 0 {
+		step.With = make(map[string]string, len(p.Inputs))
+		for _, input := range p.Inputs {
+			step.With[input.Name] = fmt.Sprintf("${{ inputs.%s }}", input.Name)
+		}
+	}
+	return step
+}
+
+// The --with and env vars passed to the dagger-for-github call in the
+// composite action's internal step.
+func (p *Pipeline) daggerCallArgs() (with map[string]string, env map[string]string) {
+	with = map[string]string{
+		"version": p.DaggerVersion,
+		"module":  p.Module,
+		"args":    p.Command,
 	}
 	if !p.NoTraces {
 		if p.PublicToken != "" {
-			step.With["cloud-token"] = p.PublicToken
+			with["cloud-token"] = p.PublicToken
 		} else {
-			step.With["cloud-token"] = "${{ secrets.DAGGER_CLOUD_TOKEN }}"
+			with["cloud-token"] = "${{ secrets.DAGGER_CLOUD_TOKEN }}"
 		}
 	}
-	return step
+	if len(p.Env) > 0 || len(p.Secrets) > 0 {
+		env = make(map[string]string, len(p.Env)+len(p.Secrets))
+		for name, value := range p.Env {
+			env[name] = value
+		}
+		for _, secret := range p.Secrets {
+			env[secret.envName()] = fmt.Sprintf("${{ secrets.%s }}", secret.Name)
+		}
+	}
+	return with, env
+}
+
+// The composite-action step configuring AWS credentials via Github OIDC, if
+// WithAWSOIDC was called on this pipeline. Returns nil otherwise.
+func (p *Pipeline) awsOIDCCompositeStep() *CompositeActionStep {
+	if p.AWSOIDC == nil {
+		return nil
+	}
+	return &CompositeActionStep{
+		Name: "Configure AWS credentials",
+		Uses: "aws-actions/configure-aws-credentials@v4",
+		With: map[string]string{
+			"role-to-assume": p.AWSOIDC.RoleARN,
+			"aws-region":     p.AWSOIDC.Region,
+		},
+	}
+}
+
+// The marker used to upsert this pipeline's sticky PR comment. When a matrix
+// is in play, every leg runs as an independent job and would otherwise race
+// to upsert the same comment under PRCommentHeader; suffixing the job index
+// gives each leg its own comment instead.
+func (p *Pipeline) prCommentHeader() string {
+	if p.Matrix != nil {
+		return fmt.Sprintf("%s (${{ strategy.job-index }})", p.PRCommentHeader)
+	}
+	return p.PRCommentHeader
+}
+
+// Steps which capture the Dagger call's output and post it as a sticky comment
+// on the triggering pull request. Returns nil if PR commenting is not enabled.
+func (p *Pipeline) prCommentSteps() []JobStep {
+	if p.PRCommentHeader == "" {
+		return nil
+	}
+	const maxCommentLength = 65000
+	return []JobStep{
+		{
+			ID:   "dagger-comment-body",
+			Name: "Prepare PR comment body",
+			If:   "always()",
+			// DAGGER_OUTPUT comes from the Dagger call and may contain
+			// untrusted content from the PR branch (e.g. test/lint output).
+			// It must be passed in via env, never interpolated into the
+			// script itself, since Github substitutes '${{ }}' expressions
+			// into the script text before the shell runs it.
+			Env: map[string]string{
+				"DAGGER_OUTPUT": "${{ steps.dagger.outputs.output }}",
+			},
+			Run: fmt.Sprintf(`printf '%%s' "$DAGGER_OUTPUT" > dagger-output.txt
+if [ "$(wc -c < dagger-output.txt)" -gt %d ]; then
+  head -c %d dagger-output.txt > dagger-comment.txt
+  echo >> dagger-comment.txt
+  echo "... (truncated, see the [full run log](${GITHUB_SERVER_URL}/${GITHUB_REPOSITORY}/actions/runs/${GITHUB_RUN_ID}))" >> dagger-comment.txt
+else
+  mv dagger-output.txt dagger-comment.txt
+fi
+`, maxCommentLength, maxCommentLength),
+		},
+		{
+			Name: "Post sticky PR comment",
+			If:   "always()",
+			Uses: "marocchino/sticky-pull-request-comment@v2",
+			With: map[string]string{
+				"header": p.prCommentHeader(),
+				"path":   "dagger-comment.txt",
+			},
+		},
+	}
 }
 
-func (p *Pipeline) githubAction() Action {
-	var env = make(map[string]string)
-	if p.PublicToken != "" {
-		env["DAGGER_CLOUD_TOKEN"] = p.PublicToken
+// Render this pipeline as a reusable Github composite action, usable from
+// other workflows or vendored into external repositories
+func (p Pipeline) AsAction(name string) *dagger.Directory {
+	return p.githubAction(name).Config(name)
+}
+
+func (p *Pipeline) githubAction(name string) Action {
+	steps := []CompositeActionStep{
+		{
+			Name: "Checkout",
+			Uses: "actions/checkout@v4",
+		},
 	}
+	if oidcStep := p.awsOIDCCompositeStep(); oidcStep != nil {
+		steps = append(steps, *oidcStep)
+	}
+	with, env := p.daggerCallArgs()
+	steps = append(steps, CompositeActionStep{
+		ID:   "dagger",
+		Name: "Dagger",
+		Uses: "dagger/dagger-for-github@v6",
+		With: with,
+		Env:  env,
+	})
+
 	action := Action{
-		Name: p.Name(),
+		Name: name,
 		Runs: Runs{
 			Using: "composite",
-			Steps: []CompositeActionStep{
-				CompositeActionStep{
-					Name: "Checkout",
-					Uses: "actions/checkout@v4",
-				},
-				CompositeActionStep{
-					Name: "Dagger",
-					Uses: "dagger/dagger-for-github@v6",
-					With: map[string]string{
-						"version": p.DaggerVersion,
-						"command": p.Command,
-						"module":  p.Module,
-					},
-					Env: env,
-				},
+			Steps: steps,
+		},
+		Outputs: map[string]ActionOutput{
+			"output": {
+				Description: "The captured output of the Dagger call",
+				Value:       "${{ steps.dagger.outputs.output }}",
 			},
 		},
 	}
+	if len(p.Inputs) > 0 {
+		action.Inputs = make(map[string]ActionInput, len(p.Inputs))
+		for _, input := range p.Inputs {
+			action.Inputs[input.Name] = ActionInput{
+				Description: input.Description,
+				Default:     input.Default,
+				Required:    input.Required,
+			}
+		}
+	}
 
 	return action
 }